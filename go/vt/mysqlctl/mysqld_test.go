@@ -92,6 +92,26 @@ func TestParseVersionString(t *testing.T) {
 			version:       ServerVersion{10, 4, 6},
 			flavor:        FlavorMariaDB,
 		},
+		{
+			versionString: "mysqld  Ver 10.5.23-MariaDB for Linux on x86_64 (MariaDB Server)",
+			version:       ServerVersion{10, 5, 23},
+			flavor:        FlavorMariaDB,
+		},
+		{
+			versionString: "mysqld  Ver 10.6.18-MariaDB for Linux on x86_64 (MariaDB Server)",
+			version:       ServerVersion{10, 6, 18},
+			flavor:        FlavorMariaDB,
+		},
+		{
+			versionString: "mysqld  Ver 10.11.8-MariaDB for Linux on x86_64 (MariaDB Server)",
+			version:       ServerVersion{10, 11, 8},
+			flavor:        FlavorMariaDB,
+		},
+		{
+			versionString: "mysqld  Ver 11.4.2-MariaDB for Linux on x86_64 (MariaDB Server)",
+			version:       ServerVersion{11, 4, 2},
+			flavor:        FlavorMariaDB,
+		},
 		{
 			versionString: "mysqld  Ver 5.6.42 for linux-glibc2.12 on x86_64 (MySQL Community Server (GPL))",
 			version:       ServerVersion{5, 6, 42},
@@ -141,40 +161,64 @@ func TestRegexps(t *testing.T) {
 		assert.Empty(t, submatch)
 	}
 
+	{
+		submatch := binlogEntryMariaGTIDRegexp.FindStringSubmatch(`#230608  1:14:31 server id 1  end_log_pos 444 	GTID 0-1-12345 trans`)
+		require.NotEmpty(t, submatch)
+	}
+	{
+		submatch := binlogEntryMariaGTIDRegexp.FindStringSubmatch(`#230608  1:14:31 server id 1  end_log_pos 322 	Query	thread_id=62	exec_time=0	error_code=0`)
+		assert.Empty(t, submatch)
+	}
+
 }
 
 func TestParseBinlogEntryTimestamp(t *testing.T) {
 	tcases := []struct {
-		name  string
-		entry string
-		tm    time.Time
+		name   string
+		entry  string
+		flavor MySQLFlavor
+		tm     time.Time
 	}{
 		{
-			name:  "empty",
-			entry: "",
+			name:   "empty",
+			entry:  "",
+			flavor: FlavorMySQL,
+		},
+		{
+			name:   "irrelevant",
+			entry:  "/*!80001 SET @@session.original_commit_timestamp=1685970394031366*//*!*/;",
+			flavor: FlavorMySQL,
 		},
 		{
-			name:  "irrelevant",
-			entry: "/*!80001 SET @@session.original_commit_timestamp=1685970394031366*//*!*/;",
+			name:   "irrelevant 2",
+			entry:  "#230605 16:06:34 server id 22233  end_log_pos 1139 CRC32 0x9fa6f3c8 	Query	thread_id=21	exec_time=0	error_code=0",
+			flavor: FlavorMySQL,
 		},
 		{
-			name:  "irrelevant 2",
-			entry: "#230605 16:06:34 server id 22233  end_log_pos 1139 CRC32 0x9fa6f3c8 	Query	thread_id=21	exec_time=0	error_code=0",
+			name:   "mysql80",
+			entry:  "#230605 16:06:34 server id 22233  end_log_pos 1037 CRC32 0xa4707c5b 	GTID	last_committed=4	sequence_number=5	rbr_only=no	original_committed_timestamp=1685970394031366	immediate_commit_timestamp=1685970394032458	transaction_length=186",
+			flavor: FlavorMySQL,
+			tm:     time.UnixMicro(1685970394031366),
 		},
 		{
-			name:  "mysql80",
-			entry: "#230605 16:06:34 server id 22233  end_log_pos 1037 CRC32 0xa4707c5b 	GTID	last_committed=4	sequence_number=5	rbr_only=no	original_committed_timestamp=1685970394031366	immediate_commit_timestamp=1685970394032458	transaction_length=186",
-			tm:    time.UnixMicro(1685970394031366),
+			name:   "mysql57",
+			entry:  "#230608 13:14:31 server id 484362839  end_log_pos 259 CRC32 0xc07510d0 	GTID	last_committed=0	sequence_number=1	rbr_only=yes",
+			flavor: FlavorMySQL,
+			tm:     time.Date(2023, time.June, 8, 13, 14, 31, 0, time.UTC),
 		},
 		{
-			name:  "mysql57",
-			entry: "#230608 13:14:31 server id 484362839  end_log_pos 259 CRC32 0xc07510d0 	GTID	last_committed=0	sequence_number=1	rbr_only=yes",
-			tm:    time.Date(2023, time.June, 8, 13, 14, 31, 0, time.UTC),
+			// MariaDB 10.5+ never carries original_committed_timestamp on the
+			// GTID line, so the MariaDB branch takes the coarser header
+			// timestamp.
+			name:   "mariadb105",
+			entry:  "#230608  1:14:31 server id 1  end_log_pos 444 	GTID 0-1-12345 trans",
+			flavor: FlavorMariaDB,
+			tm:     time.Date(2023, time.June, 8, 1, 14, 31, 0, time.UTC),
 		},
 	}
 	for _, tcase := range tcases {
 		t.Run(tcase.name, func(t *testing.T) {
-			tm, err := parseBinlogEntryTimestamp(tcase.entry)
+			tm, err := parseBinlogEntryTimestamp(tcase.entry, tcase.flavor)
 			assert.NoError(t, err)
 			assert.Equal(t, tcase.tm, tm)
 		})
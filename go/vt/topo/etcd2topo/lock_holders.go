@@ -0,0 +1,37 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package etcd2topo
+
+import (
+	"context"
+
+	"vitess.io/vitess/go/vt/topo"
+)
+
+// Server is the etcd2topo implementation of topo.Conn. The real Server
+// (client setup, directory/file access, watches, etc.) lives outside this
+// checkout; it's declared here only so LockHolders has a receiver to hang
+// off of.
+type Server struct{}
+
+// LockHolders is part of the topo.Conn interface. etcd2topo doesn't expose
+// its lease-holder queue through this API yet; unlike zk2topo, an etcd
+// lock's wait order isn't just a directory listing, so this needs its own
+// implementation rather than falling back to one.
+func (s *Server) LockHolders(ctx context.Context, dirPath string) ([]topo.LockHolderInfo, error) {
+	return nil, topo.ErrNotSupported
+}
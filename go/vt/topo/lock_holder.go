@@ -0,0 +1,46 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topo
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned by Conn.LockHolders implementations that
+// can't introspect their backend's lock wait queue (today, that's every
+// backend except zk2topo).
+var ErrNotSupported = errors.New("not supported by this topo implementation")
+
+// LockHolderInfo describes one entry in a lock's wait queue, as reported by
+// a Conn implementation that can introspect it (today, only zk2topo).
+// The queue is ordered: the first entry is the current holder, and the
+// rest are waiters in the order they will be granted the lock.
+type LockHolderInfo struct {
+	// Key identifies this entry within the backend (e.g. the ZK node path
+	// of the sequential lock node).
+	Key string
+	// Contents is the raw payload the lock was created with, typically a
+	// JSON-encoded ActionNode describing who is holding/waiting and why.
+	Contents string
+	// Ctime is when this entry was created.
+	Ctime time.Time
+	// EphemeralOwner identifies the session that owns this entry, if the
+	// backend exposes one (e.g. a Zookeeper session ID). It is 0 if the
+	// backend has no such concept.
+	EphemeralOwner int64
+}
@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/z-division/go-zookeeper/zk"
@@ -32,10 +34,52 @@ import (
 
 // This file contains the lock management code for zktopo.Server.
 
+// disconnectGracePeriod is how long we tolerate a StateDisconnected event on
+// the session event channel before declaring the lock lost. Zookeeper
+// clients reconnect transparently on blips shorter than this, so a single
+// disconnect event is not in itself fatal to the lock.
+const disconnectGracePeriod = 5 * time.Second
+
 // zkLockDescriptor implements topo.LockDescriptor.
 type zkLockDescriptor struct {
 	zs       *Server
 	nodePath string
+
+	// mu guards err, ttl, ttlTimer and ttlGen, which are set by the
+	// watcher/TTL goroutines and read by Check/Refresh.
+	mu  sync.Mutex
+	err error
+	ttl time.Duration
+	// ttlTimer is nil when the lock was acquired without a TTL.
+	ttlTimer timer
+	// ttlGen is bumped every time the TTL timer is (re)armed. onTTLExpired
+	// captures the generation it was scheduled under and only acts if it's
+	// still current, so a Refresh racing a firing timer can never have its
+	// new timer silently clobbered by the old one's fire.
+	ttlGen uint64
+	// clk creates ttlTimer; overridden by tests with a fake clock so TTL
+	// expiry/refresh can be driven deterministically.
+	clk clock
+
+	watchDone chan struct{}
+}
+
+// clock abstracts time.AfterFunc so tests can fire and cancel TTLs without
+// waiting on real time.
+type clock interface {
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer abstracts *time.Timer's Stop method, which is all the TTL watchdog
+// needs.
+type timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
 }
 
 // Lock is part of the topo.Conn interface.
@@ -43,10 +87,13 @@ func (zs *Server) Lock(ctx context.Context, dirPath, contents string) (topo.Lock
 	return zs.lock(ctx, dirPath, contents)
 }
 
-// LockWithTTL is part of the topo.Conn interface. It behaves the same as Lock
-// as TTLs are not supported in Zookeeper.
-func (zs *Server) LockWithTTL(ctx context.Context, dirPath, contents string, _ time.Duration) (topo.LockDescriptor, error) {
-	return zs.lock(ctx, dirPath, contents)
+// LockWithTTL is part of the topo.Conn interface. Zookeeper has no native
+// concept of a lease TTL, so we emulate one: a watchdog goroutine deletes
+// the ephemeral lock node once the TTL elapses, independently of whether
+// the session is still alive. Callers that want to outlive the TTL must
+// call zkLockDescriptor.Refresh before it expires.
+func (zs *Server) LockWithTTL(ctx context.Context, dirPath, contents string, ttl time.Duration) (topo.LockDescriptor, error) {
+	return zs.lockWithTTL(ctx, dirPath, contents, ttl)
 }
 
 // LockName is part of the topo.Conn interface.
@@ -82,8 +129,14 @@ func (zs *Server) TryLock(ctx context.Context, dirPath, contents string) (topo.L
 	return zs.lock(ctx, dirPath, contents)
 }
 
-// Lock is part of the topo.Conn interface.
+// lock is the TTL-less case of lockWithTTL.
 func (zs *Server) lock(ctx context.Context, dirPath, contents string) (topo.LockDescriptor, error) {
+	return zs.lockWithTTL(ctx, dirPath, contents, 0)
+}
+
+// lockWithTTL backs both Lock and LockWithTTL. A ttl of 0 means the lock
+// has no TTL watchdog.
+func (zs *Server) lockWithTTL(ctx context.Context, dirPath, contents string, ttl time.Duration) (topo.LockDescriptor, error) {
 	// Lock paths end in a trailing slash so that when we create
 	// sequential nodes, they are created as children, not siblings.
 	locksDir := path.Join(zs.root, dirPath, locksPath) + "/"
@@ -140,23 +193,255 @@ func (zs *Server) lock(ctx context.Context, dirPath, contents string) (topo.Lock
 		return nil, errToReturn
 	}
 
-	// Remove the root prefix from the file. So when we delete it,
-	// it's a relative file.
-	nodePath = nodePath[len(zs.root):]
-	return &zkLockDescriptor{
-		zs:       zs,
-		nodePath: nodePath,
-	}, nil
+	// Watch the node we just acquired so we notice if the ephemeral node
+	// disappears out from under us (session expiry, someone deleting it
+	// behind our back, etc).
+	_, _, nodeWatch, err := zs.conn.ExistsW(ctx, nodePath)
+	if err != nil {
+		// We hold the lock already; don't fail the whole Lock call over
+		// a watch we couldn't arm, just log it and carry on without
+		// session-loss detection for this lock.
+		log.Warningf("Failed to set watch on lock node %v: %v", nodePath, err)
+	}
+
+	ld := &zkLockDescriptor{
+		zs:        zs,
+		nodePath:  nodePath[len(zs.root):],
+		ttl:       ttl,
+		clk:       realClock{},
+		watchDone: make(chan struct{}),
+	}
+	if ttl > 0 {
+		ld.armTTL(ttl)
+	}
+	go ld.watch(nodeWatch, zs.sessionEvents.subscribe())
+
+	return ld, nil
+}
+
+// armTTL (re-)schedules the TTL watchdog, bumping ttlGen so that any
+// in-flight onTTLExpired call scheduled under an older generation is a
+// no-op even if it fires after this call returns.
+func (ld *zkLockDescriptor) armTTL(ttl time.Duration) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+
+	ld.ttlGen++
+	gen := ld.ttlGen
+	if ld.ttlTimer != nil {
+		ld.ttlTimer.Stop()
+	}
+	ld.ttlTimer = ld.clk.AfterFunc(ttl, func() { ld.onTTLExpired(gen) })
+}
+
+// onTTLExpired is called (on its own goroutine) when a lock's TTL elapses
+// without being refreshed. It force-deletes the ephemeral node so that
+// other waiters aren't blocked behind a lock whose holder has wedged, and
+// marks the descriptor expired so Check starts failing even if the session
+// itself is still healthy. gen is the generation this timer was armed
+// under: if a Refresh has since rearmed the timer (or the lock was already
+// lost some other way), this call is a no-op.
+func (ld *zkLockDescriptor) onTTLExpired(gen uint64) {
+	ld.mu.Lock()
+	if gen != ld.ttlGen || ld.err != nil {
+		ld.mu.Unlock()
+		return
+	}
+	ld.err = topo.NewError(topo.Timeout, ld.nodePath)
+	ld.mu.Unlock()
+
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), baseTimeout)
+	defer cancel()
+	if err := ld.zs.Delete(cleanupCtx, ld.nodePath, nil); err != nil {
+		log.Warningf("Failed to delete expired lock node %v: %v", ld.nodePath, err)
+	}
+}
+
+// Refresh re-stats the lock node and resets the TTL timer, extending the
+// lease by another full TTL period. It is a no-op for locks acquired
+// without a TTL. Mirrors the lease keepalive behavior of the etcd and
+// consul topo backends.
+//
+// Refresh only commits the new timer after re-checking err: if
+// onTTLExpired won the race and fired while Refresh was talking to
+// Zookeeper, Refresh reports that loss instead of silently "succeeding"
+// on a lock that was just force-deleted.
+func (ld *zkLockDescriptor) Refresh(ctx context.Context) error {
+	ld.mu.Lock()
+	ttl := ld.ttl
+	hasTimer := ld.ttlTimer != nil
+	alreadyErr := ld.err
+	ld.mu.Unlock()
+
+	if !hasTimer {
+		return nil
+	}
+	if alreadyErr != nil {
+		return alreadyErr
+	}
+
+	exists, _, err := ld.zs.conn.Exists(ctx, path.Join(ld.zs.root, ld.nodePath))
+	if err != nil {
+		return convertError(err, ld.nodePath)
+	}
+	if !exists {
+		err := topo.NewError(topo.NoNode, ld.nodePath)
+		ld.setErr(err)
+		return err
+	}
+
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.err != nil {
+		// onTTLExpired fired while we were talking to Zookeeper above.
+		return ld.err
+	}
+	ld.ttlGen++
+	gen := ld.ttlGen
+	if ld.ttlTimer != nil {
+		ld.ttlTimer.Stop()
+	}
+	ld.ttlTimer = ld.clk.AfterFunc(ttl, func() { ld.onTTLExpired(gen) })
+	return nil
+}
+
+// watch runs in its own goroutine for the lifetime of the lock. It waits on
+// the node watch and the session event channel, and records a sticky error
+// on the descriptor as soon as it sees the lock has been lost. It exits when
+// Unlock closes watchDone. sessionEvents is this lock's own subscription on
+// zs.sessionEvents, which watch unsubscribes on the way out.
+func (ld *zkLockDescriptor) watch(nodeWatch <-chan zk.Event, sessionEvents chan zk.Event) {
+	var disconnectedAt time.Time
+	ticker := time.NewTicker(disconnectGracePeriod)
+	defer ticker.Stop()
+	defer ld.zs.sessionEvents.unsubscribe(sessionEvents)
+
+	for {
+		select {
+		case <-ld.watchDone:
+			return
+		case event, ok := <-nodeWatch:
+			if !ok {
+				return
+			}
+			if event.Type == zk.EventNodeDeleted {
+				ld.setErr(topo.NewError(topo.NoNode, ld.nodePath))
+				return
+			}
+		case event, ok := <-sessionEvents:
+			if !ok {
+				return
+			}
+			switch event.State {
+			case zk.StateExpired:
+				ld.setErr(topo.NewError(topo.NoNode, ld.nodePath))
+				return
+			case zk.StateDisconnected:
+				disconnectedAt = time.Now()
+			case zk.StateConnected, zk.StateHasSession:
+				disconnectedAt = time.Time{}
+			}
+		case <-ticker.C:
+			if !disconnectedAt.IsZero() && time.Since(disconnectedAt) >= disconnectGracePeriod {
+				ld.setErr(topo.NewError(topo.Interrupted, ld.nodePath))
+				return
+			}
+		}
+	}
+}
+
+func (ld *zkLockDescriptor) setErr(err error) {
+	ld.mu.Lock()
+	defer ld.mu.Unlock()
+	if ld.err == nil {
+		ld.err = err
+	}
 }
 
 // Check is part of the topo.LockDescriptor interface.
 func (ld *zkLockDescriptor) Check(ctx context.Context) error {
-	// TODO(alainjobart): check the connection has not been interrupted.
-	// We'd lose the ephemeral node in case of a session loss.
+	ld.mu.Lock()
+	err := ld.err
+	ld.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Fall back to a fresh existence check in case the watcher hasn't
+	// caught up yet (e.g. the watch event hasn't been delivered).
+	exists, _, err := ld.zs.conn.Exists(ctx, path.Join(ld.zs.root, ld.nodePath))
+	if err != nil {
+		return convertError(err, ld.nodePath)
+	}
+	if !exists {
+		ld.setErr(topo.NewError(topo.NoNode, ld.nodePath))
+		return topo.NewError(topo.NoNode, ld.nodePath)
+	}
 	return nil
 }
 
+// LockHolders returns the ordered queue of entries waiting on (or holding)
+// the lock at dirPath, sorted by Zookeeper sequence number. The first entry
+// is the current holder; the rest are waiters in grant order. This lets
+// callers like vtctldclient answer "who is blocking this lock" without
+// reaching for zkcli directly.
+func (zs *Server) LockHolders(ctx context.Context, dirPath string) ([]topo.LockHolderInfo, error) {
+	dir := path.Join(zs.root, dirPath, locksPath)
+
+	children, _, err := zs.conn.Children(ctx, dir)
+	if err != nil {
+		return nil, convertError(err, dir)
+	}
+	sort.Strings(children)
+
+	holders := make([]topo.LockHolderInfo, 0, len(children))
+	for _, child := range children {
+		childPath := path.Join(dir, child)
+		data, stat, err := zs.conn.Get(ctx, childPath)
+		if err != nil {
+			// The entry may have been released between the Children call
+			// and this Get; skip it rather than failing the whole query.
+			log.Warningf("Failed to get lock queue entry %v (may have just ended): %v", childPath, err)
+			continue
+		}
+		holders = append(holders, topo.LockHolderInfo{
+			Key:            childPath,
+			Contents:       string(data),
+			Ctime:          zkStatCtime(stat),
+			EphemeralOwner: zkStatEphemeralOwner(stat),
+		})
+	}
+
+	return holders, nil
+}
+
+// zkStatCtime and zkStatEphemeralOwner pull the two Stat fields LockHolders
+// cares about. They're split out so LockHolders reads cleanly; each returns
+// the zero value for a nil stat.
+func zkStatCtime(stat *zk.Stat) time.Time {
+	if stat == nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(stat.Ctime)
+}
+
+func zkStatEphemeralOwner(stat *zk.Stat) int64 {
+	if stat == nil {
+		return 0
+	}
+	return stat.EphemeralOwner
+}
+
 // Unlock is part of the topo.LockDescriptor interface.
 func (ld *zkLockDescriptor) Unlock(ctx context.Context) error {
+	close(ld.watchDone)
+
+	ld.mu.Lock()
+	ttlTimer := ld.ttlTimer
+	ld.mu.Unlock()
+	if ttlTimer != nil {
+		ttlTimer.Stop()
+	}
+
 	return ld.zs.Delete(ctx, ld.nodePath, nil)
 }
@@ -0,0 +1,186 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zk2topo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/z-division/go-zookeeper/zk"
+
+	"vitess.io/vitess/go/vt/topo"
+)
+
+// fakeConn is a minimal Conn used to drive a zkLockDescriptor in tests
+// without standing up a real Zookeeper ensemble. It only implements enough
+// to back the node-existence checks the lock code makes.
+type fakeConn struct {
+	mu      sync.Mutex
+	exists  bool
+	deletes int
+}
+
+func (f *fakeConn) Get(ctx context.Context, path string) ([]byte, *zk.Stat, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeConn) Children(ctx context.Context, path string) ([]string, *zk.Stat, error) {
+	return nil, nil, nil
+}
+
+func (f *fakeConn) Exists(ctx context.Context, path string) (bool, *zk.Stat, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exists, nil, nil
+}
+
+func (f *fakeConn) ExistsW(ctx context.Context, path string) (bool, *zk.Stat, <-chan zk.Event, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.exists, nil, nil, nil
+}
+
+func (f *fakeConn) Delete(ctx context.Context, path string, version int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exists = false
+	f.deletes++
+	return nil
+}
+
+func (f *fakeConn) setExists(exists bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.exists = exists
+}
+
+// newTestServer builds a Server around a fake connection whose session
+// events are fed through a real sessionEventDispatcher, exactly like the
+// one newServer builds for a live connection. rawEvents is the single
+// upstream channel the dispatcher fans out from.
+func newTestServer(conn *fakeConn) (*Server, chan zk.Event) {
+	rawEvents := make(chan zk.Event, 1)
+	return &Server{conn: conn, root: "/vitess", sessionEvents: newSessionEventDispatcher(rawEvents)}, rawEvents
+}
+
+// newTestLockDescriptor builds a zkLockDescriptor wired to a fake session,
+// bypassing the real CreateRecursive/obtainQueueLock acquisition path (which
+// needs an actual Zookeeper connection) so the watcher/Check logic can be
+// exercised directly.
+func newTestLockDescriptor(conn *fakeConn) (*zkLockDescriptor, chan zk.Event) {
+	zs, rawEvents := newTestServer(conn)
+	ld := &zkLockDescriptor{
+		zs:        zs,
+		nodePath:  "/locks/lock-0000000001",
+		watchDone: make(chan struct{}),
+	}
+	go ld.watch(nil, zs.sessionEvents.subscribe())
+	return ld, rawEvents
+}
+
+// TestCheckReportsSessionLoss simulates a Zookeeper session expiring out
+// from under a held lock. The ephemeral node is reaped by the server, but
+// (as can happen in practice) the client only learns about it via a
+// StateExpired event on its own session channel, not a node watch. Check
+// must still report the loss.
+func TestCheckReportsSessionLoss(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	ld, sessionEvents := newTestLockDescriptor(conn)
+	defer close(ld.watchDone)
+
+	require.NoError(t, ld.Check(context.Background()))
+
+	// The server reaps the ephemeral node and the client's session expires.
+	conn.setExists(false)
+	sessionEvents <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+
+	assert.Eventually(t, func() bool {
+		return ld.Check(context.Background()) != nil
+	}, time.Second, 10*time.Millisecond, "Check never reported the session loss")
+
+	err := ld.Check(context.Background())
+	require.Error(t, err)
+	assert.True(t, topo.IsErrType(err, topo.NoNode))
+}
+
+// TestCheckReportsNodeDeletion covers the other way a lock can be lost
+// without a session event: the ephemeral node's watch fires directly.
+func TestCheckReportsNodeDeletion(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	zs, _ := newTestServer(conn)
+	nodeWatch := make(chan zk.Event, 1)
+	ld := &zkLockDescriptor{
+		zs:        zs,
+		nodePath:  "/locks/lock-0000000001",
+		watchDone: make(chan struct{}),
+	}
+	go ld.watch(nodeWatch, zs.sessionEvents.subscribe())
+	defer close(ld.watchDone)
+
+	require.NoError(t, ld.Check(context.Background()))
+
+	conn.setExists(false)
+	nodeWatch <- zk.Event{Type: zk.EventNodeDeleted}
+
+	assert.Eventually(t, func() bool {
+		return ld.Check(context.Background()) != nil
+	}, time.Second, 10*time.Millisecond, "Check never reported the node deletion")
+}
+
+// TestSessionEventFanoutToMultipleLocks asserts that a single session event
+// delivered on the connection's shared channel reaches every lock held on
+// that connection, not just whichever one happened to read it first. This
+// is the normal case for orchestration code (e.g. reshard/workflow) that
+// holds several locks concurrently on one zk2topo.Server.
+func TestSessionEventFanoutToMultipleLocks(t *testing.T) {
+	connA := &fakeConn{exists: true}
+	connB := &fakeConn{exists: true}
+	rawEvents := make(chan zk.Event, 1)
+	zs := &Server{conn: connA, root: "/vitess", sessionEvents: newSessionEventDispatcher(rawEvents)}
+
+	ldA := &zkLockDescriptor{zs: zs, nodePath: "/locks/lock-0000000001", watchDone: make(chan struct{})}
+	go ldA.watch(nil, zs.sessionEvents.subscribe())
+	defer close(ldA.watchDone)
+
+	ldB := &zkLockDescriptor{zs: &Server{conn: connB, root: "/vitess", sessionEvents: zs.sessionEvents}, nodePath: "/locks/lock-0000000002", watchDone: make(chan struct{})}
+	go ldB.watch(nil, zs.sessionEvents.subscribe())
+	defer close(ldB.watchDone)
+
+	require.NoError(t, ldA.Check(context.Background()))
+	require.NoError(t, ldB.Check(context.Background()))
+
+	connA.setExists(false)
+	connB.setExists(false)
+	rawEvents <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+
+	// Read the errors the watchers themselves record: Check alone would also
+	// see the missing nodes through its Exists fallback, with or without the
+	// session event.
+	assert.Eventually(t, func() bool {
+		ldA.mu.Lock()
+		errA := ldA.err
+		ldA.mu.Unlock()
+		ldB.mu.Lock()
+		errB := ldB.err
+		ldB.mu.Unlock()
+		return errA != nil && errB != nil
+	}, time.Second, 10*time.Millisecond, "both locks sharing one connection must observe the session event")
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zk2topo
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"vitess.io/vitess/go/vt/topo"
+)
+
+// fakeTimer is a controllable stand-in for *time.Timer: fire() invokes the
+// scheduled function synchronously instead of waiting on a real clock.
+type fakeTimer struct {
+	mu      sync.Mutex
+	fn      func()
+	stopped bool
+	fired   bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped || t.fired {
+		return false
+	}
+	t.stopped = true
+	return true
+}
+
+func (t *fakeTimer) fire() {
+	t.mu.Lock()
+	if t.stopped || t.fired {
+		t.mu.Unlock()
+		return
+	}
+	t.fired = true
+	fn := t.fn
+	t.mu.Unlock()
+	fn()
+}
+
+// fakeClock hands out fakeTimers instead of real ones, and remembers the
+// most recently armed one so tests can fire it on demand.
+type fakeClock struct {
+	mu     sync.Mutex
+	timers []*fakeTimer
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{fn: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) last() *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.timers[len(c.timers)-1]
+}
+
+func newTestTTLDescriptor(conn *fakeConn, ttl time.Duration) (*zkLockDescriptor, *fakeClock) {
+	clk := &fakeClock{}
+	ld := &zkLockDescriptor{
+		zs:        &Server{conn: conn, root: "/vitess"},
+		nodePath:  "/locks/lock-0000000001",
+		ttl:       ttl,
+		clk:       clk,
+		watchDone: make(chan struct{}),
+	}
+	ld.armTTL(ttl)
+	return ld, clk
+}
+
+// TestTTLExpiry asserts that once the TTL timer fires, the node is deleted
+// and Check starts reporting topo.Timeout, even though the session itself
+// is perfectly healthy.
+func TestTTLExpiry(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	ld, clk := newTestTTLDescriptor(conn, time.Minute)
+	defer close(ld.watchDone)
+
+	require.NoError(t, ld.Check(context.Background()))
+
+	clk.last().fire()
+
+	err := ld.Check(context.Background())
+	require.Error(t, err)
+	assert.True(t, topo.IsErrType(err, topo.Timeout))
+
+	conn.mu.Lock()
+	exists := conn.exists
+	conn.mu.Unlock()
+	assert.False(t, exists, "expired lock node should have been deleted")
+}
+
+// TestTTLCancelBeforeFire asserts that Unlock-ing before the TTL elapses
+// stops the watchdog for good: Unlock performs its own single intentional
+// delete, and the now-stale timer firing afterwards must be a no-op rather
+// than deleting the node a second time or setting a stray Timeout error.
+func TestTTLCancelBeforeFire(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	ld, clk := newTestTTLDescriptor(conn, time.Minute)
+
+	require.NoError(t, ld.Unlock(context.Background()))
+
+	conn.mu.Lock()
+	deletesAfterUnlock := conn.deletes
+	conn.mu.Unlock()
+	require.Equal(t, 1, deletesAfterUnlock, "Unlock should have deleted the node exactly once")
+
+	clk.last().fire()
+
+	conn.mu.Lock()
+	deletesAfterFire := conn.deletes
+	conn.mu.Unlock()
+	assert.Equal(t, deletesAfterUnlock, deletesAfterFire, "a canceled TTL timer must not delete the node again")
+
+	ld.mu.Lock()
+	err := ld.err
+	ld.mu.Unlock()
+	assert.NoError(t, err, "a canceled TTL timer must not set a Timeout error")
+}
+
+// TestTTLRefreshAfterFire asserts that if the TTL watchdog has already
+// fired (deleting the node) by the time Refresh runs, Refresh reports the
+// loss instead of resetting the timer and reporting success.
+func TestTTLRefreshAfterFire(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	ld, clk := newTestTTLDescriptor(conn, time.Minute)
+	defer close(ld.watchDone)
+
+	clk.last().fire()
+
+	err := ld.Refresh(context.Background())
+	require.Error(t, err)
+	assert.True(t, topo.IsErrType(err, topo.Timeout))
+}
+
+// TestTTLRefreshRearmsTimer asserts the common case: refreshing a healthy,
+// not-yet-expired lock resets the watchdog for another full TTL period
+// rather than leaving the old one free to fire concurrently.
+func TestTTLRefreshRearmsTimer(t *testing.T) {
+	conn := &fakeConn{exists: true}
+	ld, clk := newTestTTLDescriptor(conn, time.Minute)
+	defer close(ld.watchDone)
+
+	require.NoError(t, ld.Refresh(context.Background()))
+
+	// The old timer must no longer be able to fire...
+	first := clk.timers[0]
+	first.fire()
+	assert.NoError(t, ld.Check(context.Background()))
+
+	// ...only the newly armed one can.
+	clk.last().fire()
+	err := ld.Check(context.Background())
+	require.Error(t, err)
+	assert.True(t, topo.IsErrType(err, topo.Timeout))
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2019 The Vitess Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package zk2topo
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/z-division/go-zookeeper/zk"
+
+	"vitess.io/vitess/go/vt/log"
+)
+
+// Conn is the minimal set of Zookeeper operations the lock code in this
+// package needs from a connection. The real zk2topo.Server backs it with a
+// client that retries/reconnects under the hood; tests back it with a fake.
+type Conn interface {
+	Get(ctx context.Context, path string) (data []byte, stat *zk.Stat, err error)
+	Children(ctx context.Context, path string) (children []string, stat *zk.Stat, err error)
+	Exists(ctx context.Context, path string) (exists bool, stat *zk.Stat, err error)
+	ExistsW(ctx context.Context, path string) (exists bool, stat *zk.Stat, watch <-chan zk.Event, err error)
+	Delete(ctx context.Context, path string, version int32) error
+}
+
+// Server is the zk2topo implementation of topo.Conn. Only the fields the
+// lock code in this package touches are declared here; the rest of the
+// Server (directory/file access, the actual dial-and-retry Conn
+// implementation, etc.) lives outside this checkout.
+type Server struct {
+	conn Conn
+	root string
+
+	// sessionEvents fans the single session-state event channel handed
+	// back by the underlying zk.Conn (see zk.Connect) out to every lock
+	// started by lockWithTTL, so each one can tell a session loss
+	// (StateExpired) apart from a plain node deletion. A bare channel read
+	// would only ever reach one waiting lock; the dispatcher lets all of
+	// them observe every event, which matters because orchestration code
+	// routinely holds several locks at once.
+	sessionEvents *sessionEventDispatcher
+}
+
+// newServer wires up a Server from an already-established connection and
+// its session event channel. Called by the out-of-tree constructor that
+// dials Zookeeper (e.g. via zk.Connect) right after connecting.
+func newServer(conn Conn, root string, sessionEvents <-chan zk.Event) *Server {
+	return &Server{
+		conn:          conn,
+		root:          root,
+		sessionEvents: newSessionEventDispatcher(sessionEvents),
+	}
+}
+
+// sessionEventDispatcher reads the connection's single session-event
+// channel once and fans each event out to every currently-subscribed lock.
+// zk.Conn hands back exactly one such channel for the whole connection, but
+// every lock held on that connection needs to see every StateExpired /
+// StateDisconnected event independently.
+type sessionEventDispatcher struct {
+	mu          sync.Mutex
+	subscribers map[chan zk.Event]struct{}
+}
+
+// newSessionEventDispatcher starts fanning out events from the given
+// channel immediately; it runs for the lifetime of the connection.
+func newSessionEventDispatcher(events <-chan zk.Event) *sessionEventDispatcher {
+	d := &sessionEventDispatcher{subscribers: make(map[chan zk.Event]struct{})}
+	go d.run(events)
+	return d
+}
+
+func (d *sessionEventDispatcher) run(events <-chan zk.Event) {
+	for event := range events {
+		d.mu.Lock()
+		for ch := range d.subscribers {
+			select {
+			case ch <- event:
+			default:
+				log.Warningf("zk2topo: dropped session event %+v for a lock watcher, its subscriber channel was full", event)
+			}
+		}
+		d.mu.Unlock()
+	}
+
+	// The connection is gone for good; close every subscriber so their
+	// watch goroutines notice instead of blocking on it forever.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for ch := range d.subscribers {
+		close(ch)
+	}
+	d.subscribers = nil
+}
+
+// subscribe registers a new subscriber and returns its private event
+// channel. The caller must unsubscribe it once done (e.g. when the lock is
+// released) to avoid leaking it.
+func (d *sessionEventDispatcher) subscribe() chan zk.Event {
+	ch := make(chan zk.Event, 4)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe removes a channel previously returned by subscribe. It is a
+// no-op if the dispatcher has already shut down.
+func (d *sessionEventDispatcher) unsubscribe(ch chan zk.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.subscribers != nil {
+		delete(d.subscribers, ch)
+	}
+}
+
+// Delete is part of the topo.Conn interface. Locks in this package always
+// delete their ephemeral node unconditionally, so this forwards straight to
+// conn.Delete with no CAS check; the real Server's Delete (outside this
+// checkout) additionally validates a caller-supplied version for ordinary
+// topo files.
+func (zs *Server) Delete(ctx context.Context, filePath string, version any) error {
+	return zs.conn.Delete(ctx, path.Join(zs.root, filePath), -1)
+}